@@ -0,0 +1,105 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taskexecutor
+
+import (
+	"context"
+	"time"
+)
+
+// DrainTimeout bounds how long Stop waits for a graceful Drain to finish
+// before falling back to a hard cancel of remaining executors.
+var DrainTimeout = 30 * time.Second
+
+// Drainer is optionally implemented by a TaskExecutor that knows how to
+// checkpoint and exit cleanly at its next subtask boundary. Task types
+// that don't implement it simply keep running to completion; Drain still
+// stops any *new* work from being scheduled for them.
+type Drainer interface {
+	Drain()
+}
+
+// Drain puts the manager into "no new tasks" mode for a rolling restart:
+//   - handleExecutableTasks refuses to start new executors.
+//   - InitMeta/recoverMeta stop re-inserting this node into
+//     dist_framework_meta, so the scheduler stops assigning it new work.
+//   - already-running executors that implement Drainer are asked to
+//     checkpoint and exit at their next subtask boundary, instead of being
+//     hard-canceled the way Stop does on its own.
+//
+// It blocks until every executor has exited or ctx is done, whichever
+// comes first; a ctx with no deadline returns as soon as the drain signal
+// has been sent, without waiting for executors to finish.
+//
+// Drain may be called more than once concurrently, e.g. once by a SQL
+// admin command with no deadline and again by Stop with DrainTimeout: the
+// "stop admitting new work, signal drainers" part only happens once, but
+// every call still runs its own wait loop against whatever ctx it was
+// given - Stop always gets its grace period, even if something else put
+// the manager into drain mode first.
+func (m *Manager) Drain(ctx context.Context) {
+	if m.draining.CompareAndSwap(false, true) {
+		m.logger.Info("manager entering drain mode")
+		m.signalDrainers()
+	}
+
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		return
+	}
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for m.hasRunningExecutors() {
+		select {
+		case <-ctx.Done():
+			m.logger.Warn("drain timed out with executors still running")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// signalDrainers asks every currently running executor that implements
+// Drainer to checkpoint and exit at its next subtask boundary.
+func (m *Manager) signalDrainers() {
+	m.mu.RLock()
+	executors := make([]TaskExecutor, 0, len(m.mu.taskExecutors))
+	for _, executor := range m.mu.taskExecutors {
+		executors = append(executors, executor)
+	}
+	m.mu.RUnlock()
+
+	for _, executor := range executors {
+		if drainer, ok := executor.(Drainer); ok {
+			drainer.Drain()
+		}
+	}
+}
+
+func (m *Manager) hasRunningExecutors() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.mu.taskExecutors) > 0
+}
+
+// IsDraining reports whether the manager is in drain mode and refusing new
+// tasks. Meant to back a SQL admin command so operators can trigger and
+// observe a drain without restarting the process; no such command exists
+// yet anywhere in the tree, so today Drain/IsDraining are only reachable
+// from Go, e.g. by whatever calls Stop. Wiring an admin statement (and the
+// executor plumbing to reach this Manager from it) is tracked as
+// follow-up work.
+func (m *Manager) IsDraining() bool {
+	return m.draining.Load()
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taskexecutor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/pkg/disttask/framework/proto"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDrainExecutor struct{ drained bool }
+
+func (f *fakeDrainExecutor) Drain() { f.drained = true }
+
+func TestDrainerInterfaceIsOptional(t *testing.T) {
+	drainable := &fakeDrainExecutor{}
+	var asAny any = drainable
+	d, ok := asAny.(Drainer)
+	require.True(t, ok)
+	d.Drain()
+	require.True(t, drainable.drained)
+
+	var notDrainable any = struct{}{}
+	_, ok = notDrainable.(Drainer)
+	require.False(t, ok)
+}
+
+// fakeTaskExecutor implements just enough of TaskExecutor to sit in
+// Manager.mu.taskExecutors for tests.
+type fakeTaskExecutor struct{ task *proto.Task }
+
+func (f *fakeTaskExecutor) Init(context.Context) error  { return nil }
+func (f *fakeTaskExecutor) Run(*proto.StepResource)     {}
+func (f *fakeTaskExecutor) Close()                      {}
+func (f *fakeTaskExecutor) GetTask() *proto.Task        { return f.task }
+func (f *fakeTaskExecutor) CancelRunningSubtask()       {}
+func (f *fakeTaskExecutor) Cancel()                     {}
+func (f *fakeTaskExecutor) IsRetryableError(error) bool { return false }
+
+// TestStopStillWaitsWhenAlreadyDraining guards against a regression where
+// Drain's wait loop was gated behind the same CompareAndSwap used to
+// decide whether to signal drainers once. If something else (e.g. a SQL
+// admin drain command) already flipped the manager into drain mode with
+// no deadline, Stop's own Drain(ctx-with-timeout) call must still block
+// for running executors instead of silently no-op'ing and falling
+// straight through to a hard cancel.
+func TestStopStillWaitsWhenAlreadyDraining(t *testing.T) {
+	m := newTestManager()
+	m.mu.taskExecutors[1] = &fakeTaskExecutor{task: &proto.Task{ID: 1}}
+
+	// something else already put the manager into drain mode, with no
+	// deadline, before Stop ever runs.
+	done := make(chan struct{})
+	go func() {
+		m.Drain(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Drain with no deadline should return immediately")
+	}
+	require.True(t, m.IsDraining())
+
+	// simulate the orphaned executor actually finishing shortly after.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		m.mu.Lock()
+		delete(m.mu.taskExecutors, 1)
+		m.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	m.Drain(ctx) // what Stop calls
+	require.False(t, m.hasRunningExecutors(),
+		"Drain must wait for the running executor, not return instantly because draining was already true")
+	require.Less(t, time.Since(start), time.Second,
+		"Drain must return once the executor exits, before its ctx times out")
+}
@@ -0,0 +1,228 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taskexecutor
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TaskEventType enumerates the kinds of lifecycle events a Manager emits
+// for the tasks it runs, analogous to Nomad's TaskEvent.
+type TaskEventType string
+
+const (
+	// TaskReceived is emitted each time handleTasks finds a task it's
+	// responsible for executing with no executor started and no restart
+	// pending; a task that keeps waiting (e.g. for slots) is reported again
+	// on every check, not only the first time it's seen.
+	TaskReceived TaskEventType = "TaskReceived"
+	// TaskStarted is emitted once a TaskExecutor has been initialized and
+	// its Run loop kicked off.
+	TaskStarted TaskEventType = "TaskStarted"
+	// TaskRestarting is emitted when a failed executor is being retried
+	// after a backoff delay.
+	TaskRestarting TaskEventType = "TaskRestarting"
+	// TaskNotRestarting is emitted when the restart policy gives up on a
+	// task, either because its mode forbids restarts or MaxAttempts was
+	// exceeded within the window.
+	TaskNotRestarting TaskEventType = "TaskNotRestarting"
+	// SubtaskCanceled is emitted when a running subtask is canceled, e.g.
+	// because the task moved to reverting.
+	SubtaskCanceled TaskEventType = "SubtaskCanceled"
+	// TaskPaused is emitted when the task's subtasks are paused.
+	TaskPaused TaskEventType = "TaskPaused"
+	// TaskKilled is emitted when the executor is force-canceled, e.g. by
+	// reconciliation or to free slots for a higher-priority task.
+	TaskKilled TaskEventType = "TaskKilled"
+	// DriverFailure is emitted when a task executor fails to start
+	// (factory lookup or Init) with an error that is neither retried nor
+	// fatal.
+	DriverFailure TaskEventType = "DriverFailure"
+)
+
+// TaskEvent is a single structured lifecycle event for a task.
+type TaskEvent struct {
+	TaskID int64
+	Type   TaskEventType
+	Time   time.Time
+	// Attempt is the restart attempt number; zero for events unrelated to
+	// restarts.
+	Attempt int
+	// Delay is how long until the next retry, set on TaskRestarting.
+	Delay time.Duration
+	// Err is the error message associated with the event, if any.
+	Err string
+}
+
+// EventPersister is implemented by a TaskTable that can durably store task
+// events, e.g. so `SHOW DISTRIBUTED TASK EVENTS` or a domain HTTP endpoint
+// can surface them to operators. It's optional: a TaskTable that doesn't
+// implement it simply gets in-memory-only event history. No TaskTable
+// implements it yet, so emit's persistence branch below is currently
+// always a no-op and only Subscribe's in-memory stream is reachable by an
+// operator; wiring a real implementation (and the `SHOW DISTRIBUTED TASK
+// EVENTS` surface itself) is tracked as follow-up work.
+type EventPersister interface {
+	PersistTaskEvent(ctx context.Context, event TaskEvent) error
+}
+
+// maxEventHistoryPerTask bounds the ring buffer kept for each task so a
+// long-running task's history doesn't grow unbounded.
+const maxEventHistoryPerTask = 64
+
+// maxEventHistoryTasks bounds how many distinct taskIDs the bus keeps
+// history for at once. Nothing currently tells the bus when a task
+// reaches a terminal state, so instead of leaking one history slice per
+// taskID for the life of the Manager, the oldest tracked task is evicted
+// once the limit is reached - a long-lived node cycling through many
+// tasks stays bounded at the cost of losing replay for tasks that aren't
+// recent anymore.
+const maxEventHistoryTasks = 10000
+
+// eventBus fans out TaskEvents to subscribers and keeps a bounded
+// per-task history so a newly-registered subscriber can catch up.
+type eventBus struct {
+	mu sync.Mutex
+	// taskID -> last maxEventHistoryPerTask events, oldest first.
+	history map[int64][]TaskEvent
+	// taskOrder tracks the order in which taskIDs were first seen, oldest
+	// first, so publish can evict the oldest once maxEventHistoryTasks is
+	// exceeded.
+	taskOrder *list.List
+	taskElems map[int64]*list.Element
+	subs      map[int]chan TaskEvent
+	nextSub   int
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		history:   make(map[int64][]TaskEvent),
+		taskOrder: list.New(),
+		taskElems: make(map[int64]*list.Element),
+		subs:      make(map[int]chan TaskEvent),
+	}
+}
+
+func (b *eventBus) publish(event TaskEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.taskElems[event.TaskID]; !ok {
+		if b.taskOrder.Len() >= maxEventHistoryTasks {
+			oldest := b.taskOrder.Front()
+			oldestID := oldest.Value.(int64)
+			b.taskOrder.Remove(oldest)
+			delete(b.taskElems, oldestID)
+			delete(b.history, oldestID)
+		}
+		b.taskElems[event.TaskID] = b.taskOrder.PushBack(event.TaskID)
+	}
+
+	hist := append(b.history[event.TaskID], event)
+	if len(hist) > maxEventHistoryPerTask {
+		hist = hist[len(hist)-maxEventHistoryPerTask:]
+	}
+	b.history[event.TaskID] = hist
+
+	for _, ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+			// slow subscriber, drop rather than block event emission.
+		}
+	}
+}
+
+// subscribe registers a new subscriber, replaying the current history of
+// known tasks into it before returning, so the subscriber catches up on
+// events it missed. At most the channel's buffer of 256 events is
+// replayed; the rest of the backlog is dropped, and tasks are replayed in
+// no particular order. The returned cancel func unregisters it.
+func (b *eventBus) subscribe() (<-chan TaskEvent, func()) {
+	b.mu.Lock()
+	// buffered generously so the catch-up replay below rarely has to drop
+	// anything, but it's still bounded: with several tracked tasks each
+	// holding a full maxEventHistoryPerTask backlog, the replay can easily
+	// exceed any fixed buffer size.
+	ch := make(chan TaskEvent, 256)
+	var replay []TaskEvent
+	for _, events := range b.history {
+		replay = append(replay, events...)
+	}
+	b.mu.Unlock()
+
+	// send the catch-up replay before ch is registered in b.subs, and
+	// without holding b.mu: publish() also needs the lock, so a blocking
+	// send here while holding it would wedge every other emit() call (and
+	// so the manager's task-handling loops) behind this one subscriber.
+	// Replaying before registration also means a concurrent publish can't
+	// be delivered on ch ahead of the backlog it comes after - the only
+	// cost is that an event published in the narrow window between the
+	// snapshot above and the registration below can be missed; that's the
+	// same trade every other slow-subscriber path here makes. If the
+	// buffer fills, drop the rest of the backlog rather than block - a
+	// slow subscriber shouldn't stall the manager.
+replayLoop:
+	for _, e := range replay {
+		select {
+		case ch <- e:
+		default:
+			// channel is full; drop the remaining backlog rather than
+			// block waiting for a subscriber to drain it.
+			break replayLoop
+		}
+	}
+
+	b.mu.Lock()
+	id := b.nextSub
+	b.nextSub++
+	b.subs[id] = ch
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if ch, ok := b.subs[id]; ok {
+			delete(b.subs, id)
+			close(ch)
+		}
+	}
+}
+
+// emit publishes event on the manager's bus and, if the task table
+// supports it, persists it for durable operator visibility.
+func (m *Manager) emit(event TaskEvent) {
+	m.events.publish(event)
+	persister, ok := m.taskTable.(EventPersister)
+	if !ok {
+		return
+	}
+	if err := persister.PersistTaskEvent(m.ctx, event); err != nil {
+		m.logger.Warn("failed to persist task event", zap.Error(err))
+	}
+}
+
+// Subscribe returns a channel of TaskEvents and a cancel func to stop
+// receiving them. The channel is pre-seeded with the recent history of
+// the tasks the manager currently knows about, up to 256 events in all;
+// any older backlog beyond that is not replayed.
+func (m *Manager) Subscribe() (<-chan TaskEvent, func()) {
+	return m.events.subscribe()
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taskexecutor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventBusSubscribeReceivesNewEvents(t *testing.T) {
+	bus := newEventBus()
+	ch, cancel := bus.subscribe()
+	defer cancel()
+
+	bus.publish(TaskEvent{TaskID: 1, Type: TaskStarted, Time: time.Now()})
+
+	select {
+	case e := <-ch:
+		require.Equal(t, TaskStarted, e.Type)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+}
+
+func TestEventBusCatchesUpNewSubscriber(t *testing.T) {
+	bus := newEventBus()
+	bus.publish(TaskEvent{TaskID: 1, Type: TaskReceived, Time: time.Now()})
+	bus.publish(TaskEvent{TaskID: 1, Type: TaskStarted, Time: time.Now()})
+
+	ch, cancel := bus.subscribe()
+	defer cancel()
+
+	first := <-ch
+	second := <-ch
+	require.Equal(t, TaskReceived, first.Type)
+	require.Equal(t, TaskStarted, second.Type)
+}
+
+func TestEventBusHistoryBounded(t *testing.T) {
+	bus := newEventBus()
+	for i := 0; i < maxEventHistoryPerTask*2; i++ {
+		bus.publish(TaskEvent{TaskID: 1, Type: TaskStarted, Time: time.Now()})
+	}
+	require.Len(t, bus.history[1], maxEventHistoryPerTask)
+}
+
+func TestEventBusEvictsOldestTaskOnceLimitExceeded(t *testing.T) {
+	bus := newEventBus()
+	for taskID := int64(0); taskID < maxEventHistoryTasks; taskID++ {
+		bus.publish(TaskEvent{TaskID: taskID, Type: TaskStarted, Time: time.Now()})
+	}
+	require.Len(t, bus.history, maxEventHistoryTasks)
+	require.Contains(t, bus.history, int64(0))
+
+	// one more distinct taskID should evict taskID 0, the oldest tracked,
+	// rather than growing past the limit.
+	bus.publish(TaskEvent{TaskID: maxEventHistoryTasks, Type: TaskStarted, Time: time.Now()})
+
+	require.Len(t, bus.history, maxEventHistoryTasks)
+	require.NotContains(t, bus.history, int64(0))
+	require.Contains(t, bus.history, int64(maxEventHistoryTasks))
+}
+
+func TestEventBusCancelClosesChannel(t *testing.T) {
+	bus := newEventBus()
+	ch, cancel := bus.subscribe()
+	cancel()
+	_, ok := <-ch
+	require.False(t, ok)
+}
+
+// TestEventBusSubscribeDoesNotBlockPublish reproduces the scenario where a
+// subscriber's catch-up replay overflows its channel buffer: with enough
+// tracked tasks holding a full history, the replay exceeds any fixed
+// buffer size. subscribe must not hold the bus lock while sending that
+// replay, or publish (called from Manager.emit on every task-handling
+// tick) would deadlock behind it.
+func TestEventBusSubscribeDoesNotBlockPublish(t *testing.T) {
+	bus := newEventBus()
+	// enough tasks * full history to comfortably exceed the subscribe
+	// channel's buffer size.
+	const numTasks = 10
+	for taskID := int64(0); taskID < numTasks; taskID++ {
+		for i := 0; i < maxEventHistoryPerTask; i++ {
+			bus.publish(TaskEvent{TaskID: taskID, Type: TaskStarted, Time: time.Now()})
+		}
+	}
+	require.Greater(t, numTasks*maxEventHistoryPerTask, 256)
+
+	done := make(chan struct{})
+	go func() {
+		_, cancel := bus.subscribe()
+		defer cancel()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscribe blocked on an overflowing replay")
+	}
+
+	// publish must still be able to take the lock right after subscribe.
+	publishDone := make(chan struct{})
+	go func() {
+		bus.publish(TaskEvent{TaskID: 0, Type: TaskStarted, Time: time.Now()})
+		close(publishDone)
+	}()
+	select {
+	case <-publishDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("publish blocked after a subscribe with an overflowing replay")
+	}
+}
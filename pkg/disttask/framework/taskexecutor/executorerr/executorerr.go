@@ -0,0 +1,151 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package executorerr classifies the errors returned by a TaskExecutor so
+// the manager can decide what to do with them: retry with backoff, fail
+// the subtask immediately, or treat the whole manager as broken.
+//
+// A task-type implementation is meant to wrap its driver-level errors with
+// Retryable, NonRetryable or Fatal at the point they're returned, or
+// register a Classifier for its task type via RegisterErrorClassifier so
+// errors that aren't explicitly wrapped still get mapped to the right
+// Category. This package lands the taxonomy itself; no task type wraps its
+// errors or registers a classifier yet, so Classify currently returns
+// CategoryUnknown for every real error and manager.logErrAndPersist falls
+// back to the legacy TaskExecutor.IsRetryableError boolean. Migrating
+// existing task types (add-index, import-into, ...) to use this package is
+// tracked as follow-up work.
+package executorerr
+
+import "sync"
+
+// Category describes how the manager should react to an error returned by
+// a TaskExecutor.
+type Category int
+
+const (
+	// CategoryUnknown means the error carries no classification and no
+	// registered classifier recognized it either; callers should apply
+	// their own default.
+	CategoryUnknown Category = iota
+	// CategoryRetryable means the executor may be restarted, subject to
+	// the task's restart policy.
+	CategoryRetryable
+	// CategoryNonRetryable means the subtask should be failed immediately.
+	CategoryNonRetryable
+	// CategoryFatal means the error indicates the manager itself is in a
+	// broken state (e.g. disk full, corrupted local state) and should
+	// stop rather than keep scheduling any task.
+	CategoryFatal
+)
+
+// String implements fmt.Stringer.
+func (c Category) String() string {
+	switch c {
+	case CategoryRetryable:
+		return "retryable"
+	case CategoryNonRetryable:
+		return "non-retryable"
+	case CategoryFatal:
+		return "fatal"
+	default:
+		return "unknown"
+	}
+}
+
+// categorized wraps an error with an explicit Category.
+type categorized struct {
+	error
+	category Category
+}
+
+// Unwrap allows errors.Is/As to see through the classification.
+func (c *categorized) Unwrap() error { return c.error }
+
+func wrap(err error, category Category) error {
+	if err == nil {
+		return nil
+	}
+	return &categorized{error: err, category: category}
+}
+
+// Retryable marks err as retryable: the manager will restart the executor
+// according to the task's restart policy.
+func Retryable(err error) error { return wrap(err, CategoryRetryable) }
+
+// NonRetryable marks err as non-retryable: the manager will fail the
+// subtask right away.
+func NonRetryable(err error) error { return wrap(err, CategoryNonRetryable) }
+
+// Fatal marks err as fatal: the manager will fail the subtask and stop
+// itself rather than keep scheduling other tasks.
+func Fatal(err error) error { return wrap(err, CategoryFatal) }
+
+// categoryOf reports the Category explicitly attached to err via Retryable,
+// NonRetryable or Fatal, if any.
+func categoryOf(err error) (Category, bool) {
+	for err != nil {
+		if c, ok := err.(*categorized); ok {
+			return c.category, true
+		}
+		u, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			break
+		}
+		err = u.Unwrap()
+	}
+	return CategoryUnknown, false
+}
+
+// Classifier maps a driver-level error to a Category for a given task type.
+// It should return CategoryUnknown for errors it doesn't recognize, so the
+// caller's default applies. Only one Classifier is kept per task type.
+type Classifier func(err error) Category
+
+var (
+	mu          sync.RWMutex
+	classifiers = map[string]Classifier{}
+)
+
+// RegisterErrorClassifier registers the Classifier used to categorize
+// errors produced while running tasks of the given type. taskType is
+// typically a proto.TaskType; it's accepted as a string here so this leaf
+// package doesn't have to depend on the proto package.
+func RegisterErrorClassifier(taskType string, classifier Classifier) {
+	mu.Lock()
+	defer mu.Unlock()
+	classifiers[taskType] = classifier
+}
+
+func classifierFor(taskType string) Classifier {
+	mu.RLock()
+	defer mu.RUnlock()
+	return classifiers[taskType]
+}
+
+// Classify returns the Category explicitly attached to err, if any,
+// falling back to the Classifier registered for taskType. It returns
+// CategoryUnknown if neither applies, leaving the decision to the caller.
+func Classify(taskType string, err error) Category {
+	if err == nil {
+		return CategoryUnknown
+	}
+	if c, ok := categoryOf(err); ok {
+		return c
+	}
+	if classifier := classifierFor(taskType); classifier != nil {
+		return classifier(err)
+	}
+	return CategoryUnknown
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executorerr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyExplicitWrap(t *testing.T) {
+	base := errors.New("connection reset")
+	require.Equal(t, CategoryRetryable, Classify("import-into", Retryable(base)))
+	require.Equal(t, CategoryNonRetryable, Classify("import-into", NonRetryable(base)))
+	require.Equal(t, CategoryFatal, Classify("import-into", Fatal(base)))
+}
+
+func TestClassifyUnwrapsThroughFmtWrap(t *testing.T) {
+	base := Retryable(errors.New("disk full"))
+	wrapped := fmt.Errorf("doing thing: %w", base)
+	require.Equal(t, CategoryRetryable, Classify("add-index", wrapped))
+}
+
+func TestClassifyFallsBackToRegisteredClassifier(t *testing.T) {
+	RegisterErrorClassifier("t-test-classify", func(err error) Category {
+		if err.Error() == "schema mismatch" {
+			return CategoryNonRetryable
+		}
+		return CategoryUnknown
+	})
+
+	require.Equal(t, CategoryNonRetryable, Classify("t-test-classify", errors.New("schema mismatch")))
+	require.Equal(t, CategoryUnknown, Classify("t-test-classify", errors.New("something else")))
+}
+
+func TestClassifyUnknownWithoutClassifier(t *testing.T) {
+	require.Equal(t, CategoryUnknown, Classify("t-test-no-classifier", errors.New("boom")))
+}
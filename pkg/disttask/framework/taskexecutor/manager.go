@@ -26,6 +26,7 @@ import (
 	"github.com/pingcap/tidb/pkg/config"
 	"github.com/pingcap/tidb/pkg/disttask/framework/proto"
 	"github.com/pingcap/tidb/pkg/disttask/framework/storage"
+	"github.com/pingcap/tidb/pkg/disttask/framework/taskexecutor/executorerr"
 	"github.com/pingcap/tidb/pkg/metrics"
 	tidbutil "github.com/pingcap/tidb/pkg/util"
 	"github.com/pingcap/tidb/pkg/util/cpu"
@@ -71,6 +72,19 @@ type Manager struct {
 
 	totalCPU int
 	totalMem int64
+
+	restartsMu sync.Mutex
+	// taskID -> restartInfo, tracks pending/previous restarts scheduled by
+	// logErrAndPersist.
+	restarts map[int64]*restartInfo
+
+	events *eventBus
+
+	lostTries *reconcileTries
+
+	// draining is set by Drain to stop the manager from starting any new
+	// task executor, as part of a graceful rolling restart.
+	draining atomic.Bool
 }
 
 // NewManager creates a new task executor Manager.
@@ -95,12 +109,15 @@ func NewManager(ctx context.Context, id string, taskTable TaskTable) (*Manager,
 		id:          id,
 		taskTable:   taskTable,
 		logger:      logger,
-		slotManager: newSlotManager(totalCPU),
+		slotManager: newSlotManager(totalCPU, int64(totalMem)),
 		totalCPU:    totalCPU,
 		totalMem:    int64(totalMem),
 	}
 	m.ctx, m.cancel = context.WithCancel(ctx)
 	m.mu.taskExecutors = make(map[int64]TaskExecutor)
+	m.restarts = make(map[int64]*restartInfo)
+	m.events = newEventBus()
+	m.lostTries = newReconcileTries()
 
 	return m, nil
 }
@@ -109,6 +126,9 @@ func NewManager(ctx context.Context, id string, taskTable TaskTable) (*Manager,
 // not a must-success step before start manager,
 // manager will try to recover meta periodically.
 func (m *Manager) InitMeta() (err error) {
+	if m.draining.Load() {
+		return nil
+	}
 	for i := 0; i < retrySQLTimes; i++ {
 		err = m.taskTable.InitMeta(m.ctx, m.id, config.GetGlobalConfig().Instance.TiDBServiceScope)
 		if err == nil {
@@ -129,6 +149,9 @@ func (m *Manager) InitMeta() (err error) {
 }
 
 func (m *Manager) recoverMeta() (err error) {
+	if m.draining.Load() {
+		return nil
+	}
 	for i := 0; i < retrySQLTimes; i++ {
 		err = m.taskTable.RecoverMeta(m.ctx, m.id, config.GetGlobalConfig().Instance.TiDBServiceScope)
 		if err == nil {
@@ -153,6 +176,7 @@ func (m *Manager) Start() error {
 	m.logger.Info("task executor manager start")
 	m.wg.Run(m.handleTasksLoop)
 	m.wg.Run(m.recoverMetaLoop)
+	m.wg.Run(m.reconcileLoop)
 	return nil
 }
 
@@ -162,11 +186,25 @@ func (m *Manager) Cancel() {
 	m.cancel()
 }
 
-// Stop stops the Manager.
+// Stop stops the Manager. It first tries a graceful Drain, bounded by
+// DrainTimeout, so in-flight subtasks get a chance to checkpoint and exit
+// cleanly before running executors are hard-canceled.
 func (m *Manager) Stop() {
+	drainCtx, cancelDrain := context.WithTimeout(m.ctx, DrainTimeout)
+	m.Drain(drainCtx)
+	cancelDrain()
+
 	m.cancel()
-	m.executorWG.Wait()
+	// wg must be waited on before executorWG: every path that starts a new
+	// task executor (handleTasksLoop's own dispatch, and scheduleRestart's
+	// delayed-restart timer) runs under wg, and registers the executor on
+	// executorWG before its wg-tracked goroutine returns. Waiting for wg
+	// first therefore guarantees no more executors can be registered by the
+	// time executorWG.Wait() runs; waiting the other way around left a
+	// window where a restart timer could still register a fresh executor on
+	// executorWG after executorWG.Wait() had already returned.
 	m.wg.Wait()
+	m.executorWG.Wait()
 }
 
 // handleTasksLoop handle tasks of interested states, including:
@@ -207,7 +245,13 @@ func (m *Manager) handleTasks() {
 				m.cancelRunningSubtaskOf(task.ID)
 			}
 			// TaskStateReverting require executor to run rollback logic.
-			if !m.isExecutorStarted(task.ID) {
+			// A task with a pending delayed restart isn't started yet
+			// either, but must not be handed to handleExecutableTasks
+			// again: scheduleRestart's timer goroutine already owns
+			// starting it, and running both would double-allocate its
+			// slot and start two executors for one task.
+			if !m.isExecutorStarted(task.ID) && !m.hasPendingRestart(task.ID) {
+				m.emit(TaskEvent{TaskID: task.ID, Type: TaskReceived, Time: time.Now()})
 				executableTasks = append(executableTasks, task)
 			}
 		case proto.TaskStatePausing:
@@ -224,6 +268,10 @@ func (m *Manager) handleTasks() {
 
 // handleExecutableTasks handles executable tasks.
 func (m *Manager) handleExecutableTasks(taskInfos []*storage.TaskExecInfo) {
+	if m.draining.Load() {
+		m.logger.Debug("manager is draining, refusing to start new task executors")
+		return
+	}
 	for _, task := range taskInfos {
 		canAlloc, tasksNeedFree := m.slotManager.canAlloc(task.Task)
 		if len(tasksNeedFree) > 0 {
@@ -243,25 +291,36 @@ func (m *Manager) handleExecutableTasks(taskInfos []*storage.TaskExecInfo) {
 // cancelRunningSubtaskOf cancels the running subtask of the task, the subtask
 // will switch to `canceled` state.
 func (m *Manager) cancelRunningSubtaskOf(taskID int64) {
+	m.clearRestartInfo(taskID)
 	m.mu.RLock()
-	defer m.mu.RUnlock()
-	if executor, ok := m.mu.taskExecutors[taskID]; ok {
+	executor, ok := m.mu.taskExecutors[taskID]
+	m.mu.RUnlock()
+	if ok {
 		m.logger.Info("onCanceledTasks", zap.Int64("task-id", taskID))
 		executor.CancelRunningSubtask()
+		// emit persists the event via taskTable, which may block on a DB
+		// call - do it after releasing the lock so it doesn't stall every
+		// other task executor's start/exit path.
+		m.emit(TaskEvent{TaskID: taskID, Type: SubtaskCanceled, Time: time.Now()})
 	}
 }
 
 // onPausingTasks pauses/cancels the pending/running subtasks.
 func (m *Manager) handlePausingTask(taskID int64) error {
+	m.clearRestartInfo(taskID)
 	m.mu.RLock()
-	defer m.mu.RUnlock()
 	m.logger.Info("handle pausing task", zap.Int64("task-id", taskID))
 	if executor, ok := m.mu.taskExecutors[taskID]; ok {
 		executor.Cancel()
 	}
+	m.mu.RUnlock()
 	// we pause subtasks belongs to this exec node even when there's no executor running.
 	// as balancer might move subtasks to this node when the executor hasn't started.
-	return m.taskTable.PauseSubtasks(m.ctx, m.id, taskID)
+	err := m.taskTable.PauseSubtasks(m.ctx, m.id, taskID)
+	if err == nil {
+		m.emit(TaskEvent{TaskID: taskID, Type: TaskPaused, Time: time.Now()})
+	}
+	return err
 }
 
 // recoverMetaLoop recovers dist_framework_meta for the tidb node running the taskExecutor manager.
@@ -290,13 +349,21 @@ func (m *Manager) recoverMetaLoop() {
 // unlike cancelRunningSubtaskOf, this function doesn't change subtask state.
 func (m *Manager) cancelTaskExecutors(tasks []*proto.Task) {
 	m.mu.RLock()
-	defer m.mu.RUnlock()
+	toCancel := make(map[int64]TaskExecutor, len(tasks))
 	for _, task := range tasks {
-		m.logger.Info("cancelTasks", zap.Int64("task-id", task.ID))
 		if executor, ok := m.mu.taskExecutors[task.ID]; ok {
-			executor.Cancel()
+			toCancel[task.ID] = executor
 		}
 	}
+	m.mu.RUnlock()
+
+	for taskID, executor := range toCancel {
+		m.logger.Info("cancelTasks", zap.Int64("task-id", taskID))
+		executor.Cancel()
+		// emit can block on a DB call, so it runs after the lock above is
+		// released, same as cancelRunningSubtaskOf.
+		m.emit(TaskEvent{TaskID: taskID, Type: TaskKilled, Time: time.Now()})
+	}
 }
 
 // TestContext only used in tests.
@@ -305,26 +372,33 @@ type TestContext struct {
 	mockDown           atomic.Bool
 }
 
-// startTaskExecutor handles a runnable task.
+// startTaskExecutor handles a runnable task. The restart policy only
+// covers this function's own failure to get an executor running (factory
+// lookup or Init): executor.Run below doesn't return an error, so a
+// failure once the executor is actually running is reported by the
+// executor itself (e.g. via FailSubtask) rather than routed back through
+// logErrAndPersist/scheduleRestart.
 func (m *Manager) startTaskExecutor(task *proto.Task) {
 	// runCtx only used in executor.Run, cancel in m.fetchAndFastCancelTasks.
 	factory := GetTaskExecutorFactory(task.Type)
 	if factory == nil {
 		err := errors.Errorf("task type %s not found", task.Type)
-		m.logErrAndPersist(err, task.ID, nil)
+		m.logErrAndPersist(err, task, nil)
 		return
 	}
 	executor := factory(m.ctx, m.id, task, m.taskTable)
 	err := executor.Init(m.ctx)
 	if err != nil {
-		m.logErrAndPersist(err, task.ID, executor)
+		m.logErrAndPersist(err, task, executor)
 		return
 	}
+	m.clearRestartInfo(task.ID)
 	m.addTaskExecutor(executor)
 	m.slotManager.alloc(task)
-	resource := m.getStepResource(task.Concurrency)
+	resource := m.getStepResource(task)
 	m.logger.Info("task executor started", zap.Int64("task-id", task.ID),
 		zap.Stringer("type", task.Type), zap.Int("remaining-slots", m.slotManager.availableSlots()))
+	m.emit(TaskEvent{TaskID: task.ID, Type: TaskStarted, Time: time.Now()})
 	m.executorWG.RunWithLog(func() {
 		defer func() {
 			m.logger.Info("task executor exit", zap.Int64("task-id", task.ID), zap.Stringer("type", task.Type))
@@ -336,11 +410,14 @@ func (m *Manager) startTaskExecutor(task *proto.Task) {
 	})
 }
 
-func (m *Manager) getStepResource(concurrency int) *proto.StepResource {
+// getStepResource returns the resource grant to hand to executor.Run for
+// task, using the same memHintFor the slotManager used to admit it, so a
+// task is never told it has a different memory budget than the one it was
+// admitted against.
+func (m *Manager) getStepResource(task *proto.Task) *proto.StepResource {
 	return &proto.StepResource{
-		CPU: proto.NewAllocatable(int64(concurrency)),
-		// same proportion as CPU
-		Mem: proto.NewAllocatable(int64(float64(concurrency) / float64(m.totalCPU) * float64(m.totalMem))),
+		CPU: proto.NewAllocatable(int64(task.Concurrency)),
+		Mem: proto.NewAllocatable(memHintFor(task, m.totalCPU, m.totalMem)),
 	}
 }
 
@@ -363,21 +440,144 @@ func (m *Manager) isExecutorStarted(taskID int64) bool {
 	return ok
 }
 
+// hasPendingRestart reports whether taskID has a delayed restart
+// currently scheduled by scheduleRestart, i.e. it will be handed to
+// startTaskExecutor by that timer goroutine on its own without needing to
+// go through handleExecutableTasks again.
+func (m *Manager) hasPendingRestart(taskID int64) bool {
+	m.restartsMu.Lock()
+	info, ok := m.restarts[taskID]
+	m.restartsMu.Unlock()
+	if !ok {
+		return false
+	}
+	return info.pending()
+}
+
 func (m *Manager) logErr(err error) {
 	m.logger.Error("task manager met error", zap.Error(err), zap.Stack("stack"))
 }
 
-func (m *Manager) logErrAndPersist(err error, taskID int64, taskExecutor TaskExecutor) {
+func (m *Manager) logErrAndPersist(err error, task *proto.Task, taskExecutor TaskExecutor) {
 	m.logErr(err)
-	// TODO we want to define err of taskexecutor.Init as fatal, but add-index have
-	// some code in Init that need retry, remove it after it's decoupled.
-	if taskExecutor != nil && taskExecutor.IsRetryableError(err) {
+	category := executorerr.Classify(task.Type.String(), err)
+	// TODO legacy task types haven't been migrated to wrap their errors or
+	// register an executorerr.Classifier yet, so fall back to the old
+	// boolean until they are; remove this once all task types adopt
+	// executorerr directly.
+	if category == executorerr.CategoryUnknown && taskExecutor != nil && taskExecutor.IsRetryableError(err) {
+		category = executorerr.CategoryRetryable
+	}
+	switch category {
+	case executorerr.CategoryRetryable:
 		m.logger.Error("met retryable err", zap.Error(err), zap.Stack("stack"))
-		return
+		m.scheduleRestart(task, err)
+	case executorerr.CategoryFatal:
+		m.haltOnFatalError(task, err)
+	default:
+		// a fired delayed restart leaves its cancel func set until the start
+		// succeeds or is rescheduled; clear it so hasPendingRestart doesn't
+		// keep reporting a restart that will never run.
+		m.clearRestartInfo(task.ID)
+		m.emit(TaskEvent{TaskID: task.ID, Type: DriverFailure, Time: time.Now(), Err: err.Error()})
+		m.failSubtask(task.ID, err)
 	}
+}
+
+// haltOnFatalError fails task's subtask and stops the manager from
+// scheduling any further task, since a fatal error means the manager's own
+// state (not just this task) may no longer be trustworthy.
+func (m *Manager) haltOnFatalError(task *proto.Task, err error) {
+	m.logger.Error("fatal error from task executor, stopping manager",
+		zap.Int64("task-id", task.ID), zap.Error(err), zap.Stack("stack"))
+	m.failSubtask(task.ID, err)
+	m.cancel()
+}
+
+func (m *Manager) failSubtask(taskID int64, err error) {
 	err1 := m.taskTable.FailSubtask(m.ctx, m.id, taskID, err)
 	if err1 != nil {
 		m.logger.Error("update to subtask failed", zap.Error(err1), zap.Stack("stack"))
 	}
 	m.logger.Error("update error to subtask", zap.Int64("task-id", taskID), zap.Error(err1), zap.Stack("stack"))
 }
+
+// scheduleRestart applies task's restart policy to cause: it either gives
+// up immediately (RestartNever, or MaxAttempts exceeded within Window) and
+// fails the subtask, or schedules a delayed re-startTaskExecutor via a
+// cancelable timer. No slot is held across the wait: startTaskExecutor
+// only allocates a slot once Init succeeds, so a waiting restart doesn't
+// need to explicitly free one.
+func (m *Manager) scheduleRestart(task *proto.Task, cause error) {
+	policy := restartPolicyFor(task.Type)
+	if policy.Mode == RestartNever {
+		m.logger.Warn("restart policy forbids retry, failing subtask", zap.Int64("task-id", task.ID))
+		m.emit(TaskEvent{TaskID: task.ID, Type: TaskNotRestarting, Time: time.Now(), Err: cause.Error()})
+		m.failSubtask(task.ID, cause)
+		return
+	}
+
+	info := m.getOrCreateRestartInfo(task.ID)
+	now := time.Now()
+	attempts := info.recordAttempt(now, policy.Window)
+	if policy.MaxAttempts > 0 && uint64(attempts) > policy.MaxAttempts {
+		m.logger.Warn("restarts exceeded, giving up", zap.Int64("task-id", task.ID),
+			zap.Uint64("max-attempts", policy.MaxAttempts), zap.Duration("window", policy.Window))
+		m.clearRestartInfo(task.ID)
+		m.emit(TaskEvent{TaskID: task.ID, Type: TaskNotRestarting, Time: time.Now(), Attempt: attempts, Err: cause.Error()})
+		m.failSubtask(task.ID, errors.Annotate(cause, "restarts exceeded"))
+		return
+	}
+
+	delay := info.nextBackoff(policy.Delay)
+	ctx, cancel := context.WithCancel(m.ctx)
+	info.setCancel(cancel)
+
+	m.logger.Info("scheduling task executor restart", zap.Int64("task-id", task.ID),
+		zap.Duration("delay", delay), zap.Int("attempt", attempts))
+	m.emit(TaskEvent{TaskID: task.ID, Type: TaskRestarting, Time: time.Now(), Attempt: attempts, Delay: delay, Err: cause.Error()})
+	m.wg.RunWithLog(func() {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		// select above can race with Stop's m.cancel(): both ctx.Done()
+		// (a child of m.ctx) and timer.C may be ready simultaneously, and
+		// select picks among ready cases at random, so re-check m.ctx
+		// after waking up instead of trusting which branch fired. This
+		// goroutine runs under m.wg, which Stop waits on before
+		// executorWG, so bailing out here whenever ctx is already done
+		// guarantees startTaskExecutor below never registers a fresh
+		// executor on executorWG after Stop has stopped waiting for it.
+		if m.ctx.Err() != nil {
+			return
+		}
+		m.startTaskExecutor(task)
+	})
+}
+
+func (m *Manager) getOrCreateRestartInfo(taskID int64) *restartInfo {
+	m.restartsMu.Lock()
+	defer m.restartsMu.Unlock()
+	info, ok := m.restarts[taskID]
+	if !ok {
+		info = newRestartInfo()
+		m.restarts[taskID] = info
+	}
+	return info
+}
+
+// clearRestartInfo cancels any pending delayed restart for taskID and
+// forgets its restart history.
+func (m *Manager) clearRestartInfo(taskID int64) {
+	m.restartsMu.Lock()
+	info, ok := m.restarts[taskID]
+	delete(m.restarts, taskID)
+	m.restartsMu.Unlock()
+	if ok {
+		info.stop()
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taskexecutor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/pkg/disttask/framework/proto"
+	"github.com/pingcap/tidb/pkg/disttask/framework/storage"
+	"github.com/pingcap/tidb/pkg/metrics"
+	tidbutil "github.com/pingcap/tidb/pkg/util"
+	"go.uber.org/zap"
+)
+
+// reconcileInterval is how often the manager cross-checks its in-memory
+// executors against the task table.
+var reconcileInterval = 30 * time.Second
+
+// ReconcileMaxTries is how many times a task may be confirmed lost,
+// re-checked at exponentially growing intervals, before its subtasks are
+// declared failed. Exported for tests.
+var ReconcileMaxTries = 3
+
+// reconcileTries counts, per taskID, how many times reconcile has
+// confirmed that task is in a state needing attention, re-checking each
+// one at an exponentially growing number of reconcile cycles apart (1,
+// 2, 4, ... cycles) rather than on every single cycle, the same shape as
+// restartInfo's backoff: a task that's actually leaked is still caught
+// and failed within ReconcileMaxTries confirmations, but a task only
+// seen lost for a cycle or two because of a slow executor spawn isn't
+// chased as aggressively.
+type reconcileTries struct {
+	mu sync.Mutex
+	// cycle counts how many reconcile() passes have run so far.
+	cycle int
+	// tries is the number of times taskID has been confirmed lost.
+	tries map[int64]int
+	// due is the cycle number at which taskID's next confirmation counts;
+	// bump calls before then are skipped, without returning a change.
+	due map[int64]int
+}
+
+func newReconcileTries() *reconcileTries {
+	return &reconcileTries{tries: make(map[int64]int), due: make(map[int64]int)}
+}
+
+// tick advances the shared cycle counter. reconcile calls this once per
+// pass, before bump.
+func (r *reconcileTries) tick() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cycle++
+}
+
+// bump records taskID as confirmed lost this cycle and returns its try
+// count, unless taskID's next confirmation isn't due yet, in which case
+// it returns the try count unchanged.
+func (r *reconcileTries) bump(taskID int64) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cycle < r.due[taskID] {
+		return r.tries[taskID]
+	}
+	r.tries[taskID]++
+	tries := r.tries[taskID]
+	r.due[taskID] = r.cycle + (1 << uint(tries-1))
+	return tries
+}
+
+// clear forgets the try count for taskID, e.g. once it's been dealt with.
+func (r *reconcileTries) clear(taskID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tries, taskID)
+	delete(r.due, taskID)
+}
+
+// prune forgets any tracked taskID not present in keep, so a task that
+// recovers on its own (an executor shows up again) doesn't carry over a
+// stale try count if it later needs reconciling again.
+func (r *reconcileTries) prune(keep map[int64]struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id := range r.tries {
+		if _, ok := keep[id]; !ok {
+			delete(r.tries, id)
+			delete(r.due, id)
+		}
+	}
+}
+
+// reconcileLoop periodically cross-checks the manager's in-memory set of
+// running task executors against the task table, to catch state-machine
+// skew between scheduler and executor that handleTasks alone can miss:
+// executors left running for a task no longer assigned here (leaked), and
+// tasks the table still shows running here with no local executor (lost),
+// similar to Mesos-style task reconciliation.
+func (m *Manager) reconcileLoop() {
+	defer tidbutil.Recover(metrics.LabelDomain, "reconcileLoop", m.reconcileLoop, false)
+	ticker := time.NewTicker(reconcileInterval)
+	for {
+		select {
+		case <-m.ctx.Done():
+			m.logger.Info("reconcile loop done")
+			return
+		case <-ticker.C:
+		}
+		m.reconcile()
+	}
+}
+
+func (m *Manager) reconcile() {
+	tasks, err := m.taskTable.GetTaskExecInfoByExecID(m.ctx, m.id)
+	if err != nil {
+		m.logErr(err)
+		return
+	}
+
+	assigned := make(map[int64]*storage.TaskExecInfo, len(tasks))
+	for _, t := range tasks {
+		assigned[t.ID] = t
+	}
+
+	m.reconcileLeaked(assigned)
+	m.lostTries.tick()
+	stillLost := m.reconcileLost(tasks)
+	m.lostTries.prune(stillLost)
+}
+
+// reconcileLeaked force-cancels any local executor whose task is no longer
+// assigned to this node, or whose task has already reached a terminal
+// state at the table but whose executor hasn't noticed yet.
+func (m *Manager) reconcileLeaked(assigned map[int64]*storage.TaskExecInfo) {
+	m.mu.RLock()
+	var leaked []int64
+	for taskID := range m.mu.taskExecutors {
+		info, ok := assigned[taskID]
+		if !ok || isTerminalTaskState(info.State) {
+			leaked = append(leaked, taskID)
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, taskID := range leaked {
+		m.logger.Warn("reconcile: killing orphaned task executor", zap.Int64("task-id", taskID))
+		m.cancelOrphanedExecutor(taskID)
+	}
+}
+
+func (m *Manager) cancelOrphanedExecutor(taskID int64) {
+	m.mu.RLock()
+	executor, ok := m.mu.taskExecutors[taskID]
+	m.mu.RUnlock()
+	if !ok {
+		return
+	}
+	// Cancel only signals the executor; it hasn't actually stopped
+	// consuming CPU/memory yet. Like every other cancellation path in this
+	// package, leave the slot held until the executor's own goroutine
+	// returns from Run() and frees it in startTaskExecutor's defer -
+	// freeing it here would let a new task be admitted into this slot
+	// while the "killed" executor is still running.
+	executor.Cancel()
+	m.emit(TaskEvent{TaskID: taskID, Type: TaskKilled, Time: time.Now()})
+}
+
+// reconcileLost finds tasks the table says are running on this node but
+// for which no local executor exists, and fails their subtasks once
+// they've been confirmed lost ReconcileMaxTries times, each confirmation
+// spaced an exponentially growing number of cycles apart (see
+// reconcileTries). It returns the set of taskIDs found lost this cycle.
+func (m *Manager) reconcileLost(tasks []*storage.TaskExecInfo) map[int64]struct{} {
+	m.mu.RLock()
+	var lost []*storage.TaskExecInfo
+	for _, t := range tasks {
+		if t.State != proto.TaskStateRunning {
+			continue
+		}
+		if _, ok := m.mu.taskExecutors[t.ID]; ok {
+			continue
+		}
+		// a task with no local executor isn't necessarily lost: it may
+		// simply be waiting for slotManager to have enough room, same as
+		// handleExecutableTasks skips starting it for now. Only chase the
+		// ones that would be admitted if we tried to start them.
+		if canAlloc, _ := m.slotManager.canAlloc(t.Task); !canAlloc {
+			continue
+		}
+		// likewise, a task whose executor failed to start with a retryable
+		// error holds no slot for the whole backoff wait (startTaskExecutor
+		// only allocates one once Init succeeds), but scheduleRestart will
+		// hand it back to startTaskExecutor once the delay elapses - it isn't
+		// lost, just waiting on its own restart timer.
+		if m.hasPendingRestart(t.ID) {
+			continue
+		}
+		lost = append(lost, t)
+	}
+	m.mu.RUnlock()
+
+	stillLost := make(map[int64]struct{}, len(lost))
+	for _, t := range lost {
+		stillLost[t.ID] = struct{}{}
+		tries := m.lostTries.bump(t.ID)
+		if tries < ReconcileMaxTries {
+			m.logger.Warn("reconcile: task has no local executor, waiting before giving up",
+				zap.Int64("task-id", t.ID), zap.Int("tries", tries), zap.Int("max-tries", ReconcileMaxTries))
+			continue
+		}
+		m.logger.Error("reconcile: task has no local executor after max tries, failing its subtasks",
+			zap.Int64("task-id", t.ID), zap.Int("max-tries", ReconcileMaxTries))
+		m.lostTries.clear(t.ID)
+		m.failSubtask(t.ID, errors.New("task executor reconciliation: no local executor running this task"))
+	}
+	return stillLost
+}
+
+func isTerminalTaskState(state proto.TaskState) bool {
+	switch state {
+	case proto.TaskStateSucceed, proto.TaskStateFailed, proto.TaskStateReverted, proto.TaskStatePaused:
+		return true
+	default:
+		return false
+	}
+}
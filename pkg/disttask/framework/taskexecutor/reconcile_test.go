@@ -0,0 +1,112 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taskexecutor
+
+import (
+	"testing"
+
+	"github.com/pingcap/tidb/pkg/disttask/framework/proto"
+	"github.com/pingcap/tidb/pkg/disttask/framework/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcileTriesBumpAndClear(t *testing.T) {
+	tries := newReconcileTries()
+	require.Equal(t, 1, tries.bump(1))
+	tries.tick()
+	require.Equal(t, 2, tries.bump(1))
+	require.Equal(t, 1, tries.bump(2))
+
+	tries.clear(1)
+	require.Equal(t, 1, tries.bump(1))
+}
+
+func TestReconcileTriesPruneForgetsStaleEntries(t *testing.T) {
+	tries := newReconcileTries()
+	tries.bump(1)
+	tries.bump(2)
+
+	tries.prune(map[int64]struct{}{2: {}})
+
+	tries.tick()
+	require.Equal(t, 1, tries.bump(1))
+	require.Equal(t, 2, tries.bump(2))
+}
+
+func TestReconcileTriesSpacesConfirmationsExponentially(t *testing.T) {
+	tries := newReconcileTries()
+
+	require.Equal(t, 1, tries.bump(10), "first confirmation always counts")
+	require.Equal(t, 1, tries.bump(10), "next confirmation isn't due on the same cycle")
+
+	tries.tick()
+	require.Equal(t, 2, tries.bump(10), "one cycle later, the 2nd confirmation is due")
+
+	tries.tick()
+	require.Equal(t, 2, tries.bump(10), "after 2 confirmations the next is due 2 cycles later, not 1")
+
+	tries.tick()
+	require.Equal(t, 3, tries.bump(10), "2 cycles after the 2nd confirmation, the 3rd is due")
+}
+
+func TestReconcileLostSkipsTasksWaitingForSlots(t *testing.T) {
+	m := newTestManager()
+	m.slotManager = newSlotManager(1, 100)
+	// fill the only CPU slot with some other task, so t1 below has nowhere
+	// to run and would be skipped by handleExecutableTasks too.
+	m.slotManager.alloc(&proto.Task{ID: 99, Concurrency: 1})
+
+	starved := &storage.TaskExecInfo{Task: &proto.Task{ID: 1, State: proto.TaskStateRunning, Concurrency: 1}}
+	stillLost := m.reconcileLost([]*storage.TaskExecInfo{starved})
+
+	require.Empty(t, stillLost, "a task only waiting for slot admission must not be treated as lost")
+	require.Equal(t, 1, m.lostTries.bump(1), "reconcileLost must not have bumped the try count already")
+}
+
+func TestReconcileLostSkipsTasksWithPendingRestart(t *testing.T) {
+	m := newTestManager()
+	m.slotManager = newSlotManager(4, 100)
+
+	// task 3 has room to run but is still waiting out its restart backoff;
+	// it holds no slot, since startTaskExecutor only allocates one once
+	// Init succeeds.
+	info := newRestartInfo()
+	info.setCancel(func() {})
+	m.restarts[3] = info
+
+	waiting := &storage.TaskExecInfo{Task: &proto.Task{ID: 3, State: proto.TaskStateRunning, Concurrency: 1}}
+	stillLost := m.reconcileLost([]*storage.TaskExecInfo{waiting})
+
+	require.Empty(t, stillLost, "a task waiting on its own restart timer must not be treated as lost")
+	require.Equal(t, 1, m.lostTries.bump(3), "reconcileLost must not have bumped the try count already")
+}
+
+func TestReconcileLostChasesTasksWithRoomToRun(t *testing.T) {
+	m := newTestManager()
+	m.slotManager = newSlotManager(4, 100)
+
+	running := &storage.TaskExecInfo{Task: &proto.Task{ID: 2, State: proto.TaskStateRunning, Concurrency: 1}}
+	stillLost := m.reconcileLost([]*storage.TaskExecInfo{running})
+
+	require.Contains(t, stillLost, int64(2))
+}
+
+func TestIsTerminalTaskState(t *testing.T) {
+	require.True(t, isTerminalTaskState(proto.TaskStateSucceed))
+	require.True(t, isTerminalTaskState(proto.TaskStateFailed))
+	require.True(t, isTerminalTaskState(proto.TaskStateReverted))
+	require.True(t, isTerminalTaskState(proto.TaskStatePaused))
+	require.False(t, isTerminalTaskState(proto.TaskStateRunning))
+}
@@ -0,0 +1,212 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taskexecutor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/pkg/disttask/framework/proto"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newTestManager builds a Manager with just enough wiring to exercise the
+// bookkeeping paths (restarts, events, reconcile tries) that don't need a
+// real TaskTable.
+func newTestManager() *Manager {
+	m := &Manager{logger: zap.NewNop()}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.mu.taskExecutors = make(map[int64]TaskExecutor)
+	m.restarts = make(map[int64]*restartInfo)
+	m.events = newEventBus()
+	m.lostTries = newReconcileTries()
+	return m
+}
+
+func TestRestartInfoWindowSliding(t *testing.T) {
+	info := newRestartInfo()
+	base := time.Now()
+	window := 10 * time.Second
+
+	require.Equal(t, 1, info.recordAttempt(base, window))
+	require.Equal(t, 2, info.recordAttempt(base.Add(2*time.Second), window))
+	require.Equal(t, 3, info.recordAttempt(base.Add(4*time.Second), window))
+
+	// this attempt is far enough in the future that the first two fall
+	// outside of the window and get trimmed.
+	require.Equal(t, 2, info.recordAttempt(base.Add(13*time.Second), window))
+}
+
+func TestRestartInfoNoWindowNeverTrims(t *testing.T) {
+	info := newRestartInfo()
+	base := time.Now()
+
+	require.Equal(t, 1, info.recordAttempt(base, 0))
+	require.Equal(t, 2, info.recordAttempt(base.Add(time.Hour), 0))
+}
+
+func TestRestartInfoBackoffGrowsAndCaps(t *testing.T) {
+	info := newRestartInfo()
+	base := 100 * time.Millisecond
+
+	d1 := info.nextBackoff(base)
+	d2 := info.nextBackoff(base)
+	d3 := info.nextBackoff(base)
+	require.Equal(t, base, d1)
+	require.Equal(t, 2*base, d2)
+	require.Equal(t, 4*base, d3)
+
+	for i := 0; i < 20; i++ {
+		info.nextBackoff(base)
+	}
+	require.LessOrEqual(t, info.nextBackoff(base), maxRestartBackoff)
+}
+
+func TestRestartInfoBackoffResetsWhenWindowTrimsToOne(t *testing.T) {
+	info := newRestartInfo()
+	base := 100 * time.Millisecond
+	window := 10 * time.Second
+	now := time.Now()
+
+	require.Equal(t, 1, info.recordAttempt(now, window))
+	info.nextBackoff(base)
+	require.Equal(t, 2, info.recordAttempt(now.Add(2*time.Second), window))
+	d := info.nextBackoff(base)
+	require.Equal(t, 2*base, d)
+
+	// far enough in the future that only this attempt remains in the
+	// window; backoff should reset back to base on the next restart.
+	require.Equal(t, 1, info.recordAttempt(now.Add(20*time.Second), window))
+	require.Equal(t, base, info.nextBackoff(base))
+}
+
+func TestRestartInfoStopCancelsPending(t *testing.T) {
+	info := newRestartInfo()
+	canceled := false
+	info.setCancel(func() { canceled = true })
+	info.stop()
+	require.True(t, canceled)
+	// stopping again is a no-op, not a double-cancel panic.
+	info.stop()
+}
+
+func TestRestartInfoPendingReflectsCancel(t *testing.T) {
+	info := newRestartInfo()
+	require.False(t, info.pending())
+
+	info.setCancel(func() {})
+	require.True(t, info.pending())
+
+	info.stop()
+	require.False(t, info.pending())
+}
+
+func TestHasPendingRestartGuardsAgainstDoubleStart(t *testing.T) {
+	m := newTestManager()
+	info := newRestartInfo()
+	m.restarts[7] = info
+
+	require.False(t, m.hasPendingRestart(7), "no delayed restart scheduled yet")
+
+	info.setCancel(func() {})
+	require.True(t, m.hasPendingRestart(7),
+		"handleTasks must treat a task with a pending restart as already started, or it'll race scheduleRestart's timer and double-start the executor")
+
+	require.False(t, m.hasPendingRestart(999), "unknown task has no pending restart")
+}
+
+func TestScheduleRestartDoesNotHoldSlotWhileWaiting(t *testing.T) {
+	m := newTestManager()
+	m.slotManager = newSlotManager(4, 400)
+	taskType := proto.TaskType("TestScheduleRestartDoesNotHoldSlotWhileWaiting")
+	RegisterRestartPolicy(taskType, RestartPolicy{
+		Mode: RestartOnFailure, MaxAttempts: 5, Window: time.Minute, Delay: time.Hour,
+	})
+	task := &proto.Task{ID: 1, Type: taskType, Concurrency: 2}
+
+	before := m.slotManager.availableSlots()
+	m.scheduleRestart(task, errors.New("boom"))
+	require.Equal(t, before, m.slotManager.availableSlots(),
+		"scheduleRestart must not hold a slot while the delayed restart is waiting out its backoff")
+
+	// cancel the pending timer before the test exits, so it doesn't fire
+	// startTaskExecutor against this bare-bones test Manager after the
+	// test has already torn down.
+	info := m.restarts[task.ID]
+	require.NotNil(t, info)
+	info.stop()
+}
+
+func TestSlotFreedDuringBackoffCanBeReallocatedOnRestart(t *testing.T) {
+	sm := newSlotManager(1, 100)
+	task := &proto.Task{ID: 1, Type: proto.TaskType("restart-realloc"), Concurrency: 1}
+
+	// the executor was running and holding the node's only slot.
+	sm.alloc(task)
+	ok, _ := sm.canAlloc(&proto.Task{ID: 2, Concurrency: 1})
+	require.False(t, ok, "no slot free while the original executor is running")
+
+	// it exits: startTaskExecutor's defer frees the slot. A start failure
+	// that goes through scheduleRestart never held a slot in the first
+	// place, since startTaskExecutor only allocates one once Init succeeds.
+	sm.free(task.ID)
+
+	// the slot stays free while a delayed restart waits out its backoff,
+	// and startTaskExecutor allocates it again like it would for any other
+	// start once the restart's Init succeeds.
+	ok, _ = sm.canAlloc(task)
+	require.True(t, ok, "slot must be free during backoff")
+	sm.alloc(task)
+	require.Equal(t, 0, sm.availableSlots())
+}
+
+// TestClearRestartInfoCancelsPendingRestart covers the same clearRestartInfo
+// call handlePausingTask makes before pausing a task's subtasks - pausing a
+// task with a pending delayed restart must cancel it exactly like canceling
+// the running subtask does. handlePausingTask itself also calls
+// m.taskTable.PauseSubtasks, and the TaskTable interface it needs isn't
+// defined in this package, so it can't be driven end-to-end from here; this
+// exercises the restart-cancellation behavior it shares with
+// cancelRunningSubtaskOf directly.
+func TestClearRestartInfoCancelsPendingRestart(t *testing.T) {
+	m := newTestManager()
+	info := newRestartInfo()
+	canceled := false
+	info.setCancel(func() { canceled = true })
+	m.restarts[42] = info
+
+	m.clearRestartInfo(42)
+
+	require.True(t, canceled, "pausing a task must cancel its pending delayed restart")
+	_, ok := m.restarts[42]
+	require.False(t, ok)
+}
+
+func TestCancelRunningSubtaskOfCancelsPendingRestart(t *testing.T) {
+	m := newTestManager()
+	info := newRestartInfo()
+	canceled := false
+	info.setCancel(func() { canceled = true })
+	m.restarts[42] = info
+
+	m.cancelRunningSubtaskOf(42)
+
+	require.True(t, canceled, "a pending delayed restart must be canceled when its subtask is canceled")
+	_, ok := m.restarts[42]
+	require.False(t, ok, "restart history should be forgotten once the task is canceled")
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taskexecutor
+
+import (
+	"testing"
+
+	"github.com/pingcap/tidb/pkg/disttask/framework/proto"
+	"github.com/stretchr/testify/require"
+)
+
+func perSlotMemHint(bytesPerSlot int64) MemHintFunc {
+	return func(task *proto.Task) int64 { return bytesPerSlot * int64(task.Concurrency) }
+}
+
+func TestSlotManagerMemoryAdmission(t *testing.T) {
+	RegisterMemHint(proto.TaskType("mem-heavy"), perSlotMemHint(100))
+
+	sm := newSlotManager(16, 1000)
+	heavy := &proto.Task{ID: 1, Type: proto.TaskType("mem-heavy"), Concurrency: 8, Priority: 1}
+	ok, needFree := sm.canAlloc(heavy)
+	require.True(t, ok)
+	require.Empty(t, needFree)
+	sm.alloc(heavy)
+
+	// 8*100 = 800 used, only 200 left: a second task needing 4*100=400
+	// doesn't fit even though CPU has plenty of room.
+	another := &proto.Task{ID: 2, Type: proto.TaskType("mem-heavy"), Concurrency: 4, Priority: 1}
+	ok, needFree = sm.canAlloc(another)
+	require.False(t, ok)
+	require.Empty(t, needFree)
+}
+
+func TestSlotManagerMemoryHintVariesPerTask(t *testing.T) {
+	// two tasks of the same type with very different data volumes should
+	// be able to get different memory reservations.
+	RegisterMemHint(proto.TaskType("import-into"), func(task *proto.Task) int64 {
+		if task.ID == 1 {
+			return 995
+		}
+		return 10
+	})
+
+	sm := newSlotManager(16, 1000)
+	small := &proto.Task{ID: 2, Type: proto.TaskType("import-into"), Concurrency: 1, Priority: 1}
+	sm.alloc(small)
+	require.Equal(t, int64(10), sm.Stats().UsedMem)
+
+	large := &proto.Task{ID: 1, Type: proto.TaskType("import-into"), Concurrency: 1, Priority: 1}
+	ok, _ := sm.canAlloc(large)
+	require.False(t, ok, "the large task's own hint, not a per-type average, should drive admission")
+}
+
+func TestSlotManagerClampsOverlargeMemHint(t *testing.T) {
+	// a MemHintFunc that overestimates a task's memory usage past totalMem
+	// must not permanently wedge it out of admission: canAlloc should
+	// still be able to admit it once nothing else is using memory.
+	RegisterMemHint(proto.TaskType("mem-overlarge"), func(*proto.Task) int64 { return 10_000 })
+
+	sm := newSlotManager(16, 1000)
+	task := &proto.Task{ID: 1, Type: proto.TaskType("mem-overlarge"), Concurrency: 1, Priority: 1}
+	ok, needFree := sm.canAlloc(task)
+	require.True(t, ok, "an overlarge hint should be clamped to totalMem, not left permanently unsatisfiable")
+	require.Empty(t, needFree)
+
+	sm.alloc(task)
+	require.Equal(t, sm.Stats().TotalMem, sm.Stats().UsedMem)
+}
+
+func TestSlotManagerPreemptsLowerPriorityOnMemoryPressure(t *testing.T) {
+	RegisterMemHint(proto.TaskType("mem-heavy2"), perSlotMemHint(100))
+
+	sm := newSlotManager(16, 1000)
+	low := &proto.Task{ID: 1, Type: proto.TaskType("mem-heavy2"), Concurrency: 8, Priority: 5}
+	sm.alloc(low)
+
+	high := &proto.Task{ID: 2, Type: proto.TaskType("mem-heavy2"), Concurrency: 4, Priority: 1}
+	ok, needFree := sm.canAlloc(high)
+	require.False(t, ok)
+	require.Len(t, needFree, 1)
+	require.Equal(t, int64(1), needFree[0].ID)
+}
+
+func TestSlotManagerStatsAndFree(t *testing.T) {
+	sm := newSlotManager(8, 800)
+	task := &proto.Task{ID: 1, Type: proto.TaskType("default"), Concurrency: 4, Priority: 1}
+	sm.alloc(task)
+
+	stats := sm.Stats()
+	require.Equal(t, 4, stats.UsedCPU)
+	require.Equal(t, int64(400), stats.UsedMem)
+
+	sm.free(task.ID)
+	stats = sm.Stats()
+	require.Equal(t, 0, stats.UsedCPU)
+	require.Equal(t, int64(0), stats.UsedMem)
+}